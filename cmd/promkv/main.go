@@ -3,7 +3,9 @@ package main
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"os"
 
@@ -17,13 +19,11 @@ func main() {
 		APIURL:   os.Getenv("PROMETHEUS_URL"),
 		WriteURL: os.Getenv("PROMETHEUS_REMOTE_WRITE_URL"),
 
-		Client: &http.Client{
-			Transport: config.NewBasicAuthRoundTripper(
-				os.Getenv("PROMETHEUS_USERNAME"),
-				config.Secret(os.Getenv("PROMETHEUS_PASSWORD")),
-				"", // No password file
-				http.DefaultTransport,
-			),
+		HTTPClientConfig: config.HTTPClientConfig{
+			BasicAuth: &config.BasicAuth{
+				Username: os.Getenv("PROMETHEUS_USERNAME"),
+				Password: config.Secret(os.Getenv("PROMETHEUS_PASSWORD")),
+			},
 		},
 	})
 
@@ -69,9 +69,75 @@ promkv looks at the following environment variables:
 				return db.Set(context.Background(), args[0], os.Stdin)
 			},
 		}
+
+		listCmd = &cobra.Command{
+			Use:   "list",
+			Short: "List the names of every value stored in promkv.",
+			Args:  cobra.NoArgs,
+
+			RunE: func(_ *cobra.Command, args []string) error {
+				names, err := db.List(context.Background())
+				if err != nil {
+					return err
+				}
+				for _, name := range names {
+					fmt.Println(name)
+				}
+				return nil
+			},
+		}
+
+		deleteCmd = &cobra.Command{
+			Use:   "delete [name]",
+			Short: "Delete a value from promkv.",
+			Args:  cobra.ExactArgs(1),
+
+			RunE: func(_ *cobra.Command, args []string) error {
+				return db.Delete(context.Background(), args[0])
+			},
+		}
+
+		statCmd = &cobra.Command{
+			Use:   "stat [name]",
+			Short: "Print metadata about a value without reading its content.",
+			Args:  cobra.ExactArgs(1),
+
+			RunE: func(_ *cobra.Command, args []string) error {
+				meta, err := db.Stat(context.Background(), args[0])
+				if err != nil {
+					return err
+				}
+				fmt.Printf("name:      %s\n", meta.Name)
+				fmt.Printf("size:      %d bytes\n", meta.Size)
+				fmt.Printf("timestamp: %s\n", meta.Timestamp)
+				fmt.Printf("version:   %d\n", meta.Version)
+				fmt.Printf("sha256:    %x\n", meta.SHA256)
+				return nil
+			},
+		}
+
+		serveListenAddr string
+		serveCmd        = &cobra.Command{
+			Use:   "serve",
+			Short: "Run a local Prometheus-compatible remote-write receiver so promkv can self-host.",
+			Long: `serve runs promkv.Server, an in-process stand-in for Prometheus that
+implements just enough of the HTTP API (/api/v1/write and
+/api/v1/query_range) for promkv get/set to work against it. Point
+PROMETHEUS_URL and PROMETHEUS_REMOTE_WRITE_URL at this server to run promkv
+end-to-end without a real Prometheus.`,
+			Args: cobra.NoArgs,
+
+			RunE: func(cmd *cobra.Command, args []string) error {
+				srv := promkv.NewServer()
+				log.Printf("promkv serve listening on %s", serveListenAddr)
+				return http.ListenAndServe(serveListenAddr, srv.Handler())
+			},
+		}
 	)
 
-	cmd.AddCommand(getCmd, setCmd)
+	serveCmd.Flags().StringVar(&serveListenAddr, "listen-addr", ":9091", "Address to listen on.")
+
+	cmd.AddCommand(getCmd, setCmd, listCmd, deleteCmd, statCmd, serveCmd)
 
 	if err := cmd.Execute(); err != nil {
 		os.Exit(1)
@@ -0,0 +1,233 @@
+package promkv
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Encoding controls how file bytes are packed into Prometheus samples.
+// Denser encodings reduce the number of samples a file needs (and therefore
+// the number of points returned by QueryRange and written in a
+// remote_write request), at the cost of no longer being readable by eyeballing
+// promkv_file_content directly in Prometheus.
+type Encoding int
+
+const (
+	// OneBytePerSample stores each byte of a file as its own sample. This is
+	// the original promkv wire format: simple, but one sample per byte means
+	// large files are slow to write and query.
+	OneBytePerSample Encoding = iota
+
+	// Float64Packed packs 6 bytes of file content into a single float64
+	// sample. A float64 mantissa holds 52 bits, enough to exactly represent
+	// any 48-bit (6 byte) unsigned integer, so no precision is lost when the
+	// packed value round-trips through remote_write and PromQL.
+	Float64Packed
+
+	// VarintDelta zigzag-delta-encodes consecutive bytes, then packs the
+	// resulting varint stream 6-bytes-per-sample the same way as
+	// Float64Packed. Every input byte becomes at least one varint byte, so
+	// VarintDelta never needs fewer samples than Float64Packed: byte streams
+	// whose consecutive bytes differ by -64..63 (text, sparse binaries) need
+	// the same number, and any larger jump costs a second varint byte.
+	VarintDelta
+
+	// NativeHistogram packs an entire 4KB frame of bytes into a single
+	// sparse native histogram sample instead of a run of float64 samples,
+	// cutting sample counts by roughly 4096x. It isn't packed into plain
+	// float64 values at all, so it's built and read separately from the
+	// other encodings; see histogram.go. Requires a Prometheus built with
+	// native histogram support enabled.
+	NativeHistogram
+)
+
+// String returns e's name as used in documentation and log lines.
+func (e Encoding) String() string {
+	switch e {
+	case OneBytePerSample:
+		return "one_byte_per_sample"
+	case Float64Packed:
+		return "float64_packed"
+	case VarintDelta:
+		return "varint_delta"
+	case NativeHistogram:
+		return "native_histogram"
+	default:
+		return fmt.Sprintf("Encoding(%d)", int(e))
+	}
+}
+
+// packSamples encodes bb into the sample values that should be written for
+// encoding e. packedLen is the byte length of the data actually packed into
+// samples (equal to len(bb) for every encoding except VarintDelta, whose
+// zigzag-delta-encoded intermediate stream is a different length); callers
+// must record it alongside the samples so unpackSamples can later reverse
+// the packing exactly.
+func packSamples(e Encoding, bb []byte) (samples []float64, packedLen int, err error) {
+	switch e {
+	case OneBytePerSample:
+		vals := make([]float64, len(bb))
+		for i, b := range bb {
+			vals[i] = float64(b)
+		}
+		return vals, len(bb), nil
+
+	case Float64Packed:
+		return packBytes(bb, e.bytesPerSampleUpperBound()), len(bb), nil
+
+	case VarintDelta:
+		encoded := deltaEncode(bb)
+		return packBytes(encoded, e.bytesPerSampleUpperBound()), len(encoded), nil
+
+	case NativeHistogram:
+		return nil, 0, fmt.Errorf("promkv: %v is packed into histograms, not float64 samples; use buildHistogramChunkWriteRequest", e)
+
+	default:
+		return nil, 0, fmt.Errorf("promkv: unknown encoding %v", e)
+	}
+}
+
+// unpackSamples decodes vals (sample values produced by packSamples) back
+// into the original bytes. size is the original, unpacked byte length.
+// packedLen is the packedLen packSamples returned when vals was written; it
+// tells packBytes-based encodings how many real bytes the final group holds,
+// since that group may be right-aligned with left-padding zeros.
+func unpackSamples(e Encoding, vals []float64, size, packedLen int) ([]byte, error) {
+	switch e {
+	case OneBytePerSample:
+		if len(vals) != size {
+			return nil, fmt.Errorf("promkv: expected %d samples for %d bytes, got %d", size, size, len(vals))
+		}
+		bb := make([]byte, size)
+		for i, v := range vals {
+			bb[i] = byte(v)
+		}
+		return bb, nil
+
+	case Float64Packed:
+		return unpackBytes(vals, packedLen, e.bytesPerSampleUpperBound())
+
+	case VarintDelta:
+		bb, err := unpackBytes(vals, packedLen, e.bytesPerSampleUpperBound())
+		if err != nil {
+			return nil, err
+		}
+		return deltaDecode(bb, size), nil
+
+	case NativeHistogram:
+		return nil, fmt.Errorf("promkv: %v is packed into histograms, not float64 samples; use decodeMetricFamilyHistogram", e)
+
+	default:
+		return nil, fmt.Errorf("promkv: unknown encoding %v", e)
+	}
+}
+
+// bytesPerSampleUpperBound returns the most bytes of raw input a single
+// sample can hold under e, used as packBytes/unpackBytes's group size. It's
+// an upper bound rather than an exact figure because VarintDelta packs a
+// variable number of input bytes per sample.
+func (e Encoding) bytesPerSampleUpperBound() int {
+	switch e {
+	case OneBytePerSample:
+		return 1
+	default:
+		return 6
+	}
+}
+
+// packBytes packs bb groupSize bytes at a time into float64 values, each
+// exactly representing the big-endian uint64 formed from those bytes
+// (right-aligned, with left-padding zeros in a final, partial group).
+func packBytes(bb []byte, groupSize int) []float64 {
+	vals := make([]float64, 0, (len(bb)+groupSize-1)/groupSize)
+	for i := 0; i < len(bb); i += groupSize {
+		end := i + groupSize
+		if end > len(bb) {
+			end = len(bb)
+		}
+
+		var buf [8]byte
+		copy(buf[8-(end-i):], bb[i:end])
+		vals = append(vals, float64(binary.BigEndian.Uint64(buf[:])))
+	}
+	return vals
+}
+
+// unpackBytes reverses packBytes, returning exactly n bytes. n is required
+// because the final group may hold fewer than groupSize real bytes,
+// right-aligned the same way packBytes wrote them; without it there's no way
+// to tell real trailing bytes from left-padding zeros. Returns an error
+// rather than panicking or silently returning the wrong length if vals
+// doesn't hold exactly as many samples as packing n bytes would have
+// produced; QueryRange can come back short of the expected sample count
+// when a query's Start/End round-trips through lossy decimal-seconds
+// formatting and excludes a sample sitting exactly on the boundary.
+func unpackBytes(vals []float64, n, groupSize int) ([]byte, error) {
+	want := (n + groupSize - 1) / groupSize
+	if len(vals) != want {
+		return nil, fmt.Errorf("promkv: expected %d samples to unpack %d bytes, got %d", want, n, len(vals))
+	}
+
+	bb := make([]byte, 0, n)
+	for i, v := range vals {
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], uint64(v))
+
+		groupLen := groupSize
+		if remaining := n - i*groupSize; remaining < groupLen {
+			groupLen = remaining
+		}
+		bb = append(bb, buf[8-groupLen:]...)
+	}
+	return bb, nil
+}
+
+// deltaEncode zigzag-delta-encodes bb and varint-encodes the result, so that
+// slowly-changing byte streams produce mostly single-byte varints.
+func deltaEncode(bb []byte) []byte {
+	out := make([]byte, 0, len(bb))
+
+	var (
+		prev     byte
+		varintBB [binary.MaxVarintLen64]byte
+	)
+	for _, b := range bb {
+		z := zigzag(b - prev)
+		n := binary.PutUvarint(varintBB[:], uint64(z))
+		out = append(out, varintBB[:n]...)
+		prev = b
+	}
+	return out
+}
+
+// deltaDecode reverses deltaEncode, reading exactly n varints from bb.
+func deltaDecode(bb []byte, n int) []byte {
+	out := make([]byte, 0, n)
+
+	var (
+		prev   byte
+		offset int
+	)
+	for i := 0; i < n; i++ {
+		z, size := binary.Uvarint(bb[offset:])
+		offset += size
+
+		prev += unzigzag(byte(z))
+		out = append(out, prev)
+	}
+	return out
+}
+
+// zigzag maps a signed byte delta to an unsigned byte so that small deltas
+// in either direction encode as small varints.
+func zigzag(d byte) byte {
+	sd := int8(d)
+	return byte((sd << 1) ^ (sd >> 7))
+}
+
+// unzigzag reverses zigzag. The shift must be logical, not arithmetic: z is
+// an unsigned byte whose top bit carries no sign, so decoding through an
+// int8 (which sign-extends on >>) corrupts every z with the top bit set.
+func unzigzag(z byte) byte {
+	return byte(z>>1) ^ byte(-int8(z&1))
+}
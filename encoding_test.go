@@ -0,0 +1,72 @@
+package promkv
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestPackUnpackSamples(t *testing.T) {
+	lengths := []int{0, 1, 5, 6, 7, 11, 12, 13, 64, 65536}
+
+	for _, enc := range []Encoding{OneBytePerSample, Float64Packed, VarintDelta} {
+		t.Run(enc.String(), func(t *testing.T) {
+			for _, n := range lengths {
+				bb := make([]byte, n)
+				rand.New(rand.NewSource(int64(n))).Read(bb)
+
+				samples, packedLen, err := packSamples(enc, bb)
+				if err != nil {
+					t.Fatalf("packSamples(%d bytes): %v", n, err)
+				}
+
+				got, err := unpackSamples(enc, samples, n, packedLen)
+				if err != nil {
+					t.Fatalf("unpackSamples(%d bytes): %v", n, err)
+				}
+				if !bytes.Equal(got, bb) {
+					t.Fatalf("round trip mismatch for %d bytes:\n want %x\n  got %x", n, bb, got)
+				}
+			}
+		})
+	}
+}
+
+func TestUnpackSamplesShortSampleCount(t *testing.T) {
+	for _, enc := range []Encoding{OneBytePerSample, Float64Packed, VarintDelta} {
+		t.Run(enc.String(), func(t *testing.T) {
+			bb := make([]byte, 11)
+			rand.New(rand.NewSource(1)).Read(bb)
+
+			samples, packedLen, err := packSamples(enc, bb)
+			if err != nil {
+				t.Fatalf("packSamples: %v", err)
+			}
+
+			if _, err := unpackSamples(enc, samples[:len(samples)-1], len(bb), packedLen); err == nil {
+				t.Fatal("unpackSamples with one fewer sample than expected returned no error")
+			}
+		})
+	}
+}
+
+func TestZigzagRoundTrip(t *testing.T) {
+	for d := 0; d < 256; d++ {
+		z := zigzag(byte(d))
+		if got := unzigzag(z); got != byte(d) {
+			t.Fatalf("unzigzag(zigzag(%d)) = %d, want %d", byte(d), got, byte(d))
+		}
+	}
+}
+
+func TestDeltaEncodeDecodeRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	bb := make([]byte, 1000)
+	r.Read(bb)
+
+	encoded := deltaEncode(bb)
+	got := deltaDecode(encoded, len(bb))
+	if !bytes.Equal(got, bb) {
+		t.Fatalf("deltaDecode(deltaEncode(bb)) did not round-trip")
+	}
+}
@@ -0,0 +1,34 @@
+package promkv
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// TestHistogramFrameRoundTrip exercises the telescoping delta math shared by
+// buildHistogramSample (prompb.Histogram, written) and
+// decodeMetricFamilyHistogram (dto.Histogram, read back via the protobuf
+// exposition format). The two types are generated from different
+// protobufs, so the PositiveDeltas are copied across by hand here instead
+// of going through a real expfmt/HTTP round trip.
+func TestHistogramFrameRoundTrip(t *testing.T) {
+	lengths := []int{0, 1, 5, 255, FrameSize}
+
+	for _, n := range lengths {
+		bb := make([]byte, n)
+		rand.New(rand.NewSource(int64(n))).Read(bb)
+
+		sample := buildHistogramSample(bb, time.Now())
+
+		h := &dto.Histogram{PositiveDelta: sample.PositiveDeltas}
+		got := decodeMetricFamilyHistogram(h)
+
+		if !bytes.Equal(got, bb) {
+			t.Fatalf("round trip mismatch for %d bytes:\n want %x\n  got %x", n, bb, got)
+		}
+	}
+}
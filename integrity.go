@@ -0,0 +1,156 @@
+package promkv
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"time"
+
+	promapi "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/prometheus/model/timestamp"
+)
+
+// Metadata describes a stored value without reading its content.
+type Metadata struct {
+	Name      string
+	Size      int64
+	Timestamp time.Time
+	SHA256    [sha256.Size]byte
+	Version   uint64
+}
+
+// ErrCorrupted is returned by Get when a value's reassembled content
+// doesn't match the sha256 digest recorded at write time. The module's own
+// README warns that data corruption is expected; this is how callers
+// actually find out it happened.
+type ErrCorrupted struct {
+	Name     string
+	Expected [sha256.Size]byte
+	Actual   [sha256.Size]byte
+}
+
+func (e *ErrCorrupted) Error() string {
+	return fmt.Sprintf("promkv: %q is corrupted: expected sha256 %x, got %x", e.Name, e.Expected, e.Actual)
+}
+
+// ErrNotFound is returned (wrapped) by Get, GetStream, and Stat when name
+// has never been written, rather than those calls failing on whatever
+// happens to be missing first.
+var ErrNotFound = errors.New("promkv: key not found")
+
+// List returns the names of every value currently stored, discovered via
+// the Prometheus label values API rather than any index promkv maintains
+// itself.
+func (db *KV) List(ctx context.Context) ([]string, error) {
+	papi, err := db.promAPI()
+	if err != nil {
+		return nil, err
+	}
+
+	values, _, err := papi.LabelValues(
+		ctx,
+		"key",
+		[]string{"promkv_file_chunks"},
+		time.Now().UTC().Add(-db.lookbackWindow()),
+		time.Now().UTC(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(values))
+	for _, v := range values {
+		names = append(names, string(v))
+	}
+	return names, nil
+}
+
+// Delete removes every series written for name via the Prometheus admin
+// API's delete_series endpoint.
+func (db *KV) Delete(ctx context.Context, name string) error {
+	papi, err := db.promAPI()
+	if err != nil {
+		return err
+	}
+
+	return papi.DeleteSeries(ctx, []string{fmt.Sprintf(`{key=%q}`, name)}, time.Time{}, time.Now().UTC())
+}
+
+// Stat returns name's metadata without reading or verifying its content.
+func (db *KV) Stat(ctx context.Context, name string) (Metadata, error) {
+	papi, err := db.promAPI()
+	if err != nil {
+		return Metadata{}, err
+	}
+
+	size, err := db.queryScalar(ctx, papi, fmt.Sprintf("promkv_file_size_bytes{key=%q}", name))
+	if err != nil {
+		return Metadata{}, fmt.Errorf("reading size for %q: %w", name, err)
+	}
+	ts, err := db.queryScalar(ctx, papi, fmt.Sprintf("promkv_file_timestamp_seconds{key=%q}", name))
+	if err != nil {
+		return Metadata{}, fmt.Errorf("reading timestamp for %q: %w", name, err)
+	}
+	version, err := db.queryScalar(ctx, papi, fmt.Sprintf("promkv_file_version{key=%q}", name))
+	if err != nil {
+		return Metadata{}, fmt.Errorf("reading version for %q: %w", name, err)
+	}
+	sum, err := db.fetchSHA256(ctx, papi, name)
+	if err != nil {
+		return Metadata{}, fmt.Errorf("reading sha256 for %q: %w", name, err)
+	}
+
+	return Metadata{
+		Name:      name,
+		Size:      int64(size),
+		Timestamp: timestamp.Time(int64(ts)),
+		SHA256:    sum,
+		Version:   uint64(version),
+	}, nil
+}
+
+// verifyChecksum compares bb against the sha256 digest recorded for name at
+// write time, returning *ErrCorrupted on mismatch.
+func (db *KV) verifyChecksum(ctx context.Context, papi promapi.API, name string, bb []byte) error {
+	expected, err := db.fetchSHA256(ctx, papi, name)
+	if err != nil {
+		return fmt.Errorf("reading sha256 for %q: %w", name, err)
+	}
+
+	actual := sha256.Sum256(bb)
+	if !bytes.Equal(expected[:], actual[:]) {
+		return &ErrCorrupted{Name: name, Expected: expected, Actual: actual}
+	}
+	return nil
+}
+
+// fetchSHA256 reads back the last 32 samples of promkv_file_sha256_bytes
+// written for name, i.e. the digest recorded by its most recent write.
+func (db *KV) fetchSHA256(ctx context.Context, papi promapi.API, name string) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+
+	val, _, err := papi.QueryRange(
+		ctx,
+		fmt.Sprintf("promkv_file_sha256_bytes{key=%q}", name),
+		promapi.Range{
+			Start: time.Now().UTC().Add(-db.lookbackWindow()),
+			End:   time.Now().UTC(),
+			Step:  time.Minute,
+		},
+	)
+	if err != nil {
+		return sum, err
+	}
+
+	vals := getValues(val)
+	if len(vals) < len(sum) {
+		return sum, fmt.Errorf("expected at least %d sha256 samples for %q, got %d", len(sum), name, len(vals))
+	}
+	vals = vals[len(vals)-len(sum):]
+	for i, v := range vals {
+		sum[i] = byte(v)
+	}
+	return sum, nil
+}
@@ -0,0 +1,55 @@
+package promkv
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metrics holds the instrumentation KV emits about its own behavior, as
+// distinct from the data it stores in the target Prometheus.
+type metrics struct {
+	operationsTotal   *prometheus.CounterVec
+	operationDuration *prometheus.HistogramVec
+	bytesTransferred  *prometheus.CounterVec
+	framesPerRequest  prometheus.Histogram
+}
+
+// newMetrics creates KV's metrics and registers them with reg, if non-nil.
+// Creating more than one KV against the same non-nil Registerer will panic,
+// the same way promauto does; give each KV its own Registerer (or a
+// prometheus.WrapRegistererWith label) if you need more than one.
+func newMetrics(reg prometheus.Registerer) *metrics {
+	m := &metrics{
+		operationsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "promkv_client_operations_total",
+			Help: "Total number of Get/Set operations the client has performed, by op and status.",
+		}, []string{"op", "status"}),
+
+		operationDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "promkv_client_operation_duration_seconds",
+			Help:    "Duration of Get/Set operations.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"op"}),
+
+		bytesTransferred: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "promkv_client_bytes_transferred_total",
+			Help: "Total bytes read or written by Get/Set, by op.",
+		}, []string{"op"}),
+
+		framesPerRequest: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "promkv_client_frames_per_request",
+			Help:    "Number of chunks a single Get or Set operation was split across.",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 10),
+		}),
+	}
+
+	if reg != nil {
+		reg.MustRegister(
+			m.operationsTotal,
+			m.operationDuration,
+			m.bytesTransferred,
+			m.framesPerRequest,
+		)
+	}
+
+	return m
+}
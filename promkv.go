@@ -1,3 +1,6 @@
+// Package promkv is an awful key-value store backed by Prometheus. Values
+// are smuggled in as sample data on synthetic time series and read back out
+// with PromQL; expect data corruption and bugs to be rampant.
 package promkv
 
 import (
@@ -6,21 +9,25 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
+	"reflect"
 	"time"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/golang/snappy"
 	"github.com/prometheus/client_golang/api"
 	promapi "github.com/prometheus/client_golang/api/prometheus/v1"
-	"github.com/prometheus/common/model"
-	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/config"
 	"github.com/prometheus/prometheus/prompb"
 )
 
 // KV is a key-value store backed by Prometheus.
 type KV struct {
-	opts Options
+	opts    Options
+	logger  *slog.Logger
+	metrics *metrics
 }
 
 // Options configures the KV client.
@@ -28,115 +35,175 @@ type Options struct {
 	APIURL   string
 	WriteURL string
 
+	// HTTPClientConfig configures the HTTP client used for both remote_write
+	// pushes and API reads: bearer tokens, TLS client certs, proxy URLs, and
+	// OAuth2 client-credential flows, resolved the same way Prometheus itself
+	// builds its remote_write clients. Takes precedence over Client.
+	HTTPClientConfig config.HTTPClientConfig
+
+	// Client is an explicit HTTP client to use instead of HTTPClientConfig.
+	//
+	// Deprecated: set HTTPClientConfig instead. Client is kept working for
+	// one release to ease migration and will be removed afterwards.
 	Client *http.Client
-}
 
-type BasicAuth struct {
-	Username, Password string
+	// Encoding controls how file bytes are packed into samples. Defaults to
+	// OneBytePerSample.
+	Encoding Encoding
+
+	// ChunkSize is the number of raw bytes written per chunk by SetStream.
+	// Values larger than ChunkSize are split across multiple "key"/"chunk"
+	// label pairs, each pushed in its own remote_write request. Defaults to
+	// DefaultChunkSize.
+	ChunkSize int
+
+	// Logger receives structured log lines about Get/Set operations. Defaults
+	// to a logger that discards everything.
+	Logger *slog.Logger
+
+	// Registerer, if non-nil, is used to register KV's operational metrics
+	// (promkv_client_operations_total and friends). Each KV needs its own
+	// Registerer (or none at all); sharing one between multiple KVs panics.
+	Registerer prometheus.Registerer
+
+	// LookbackWindow bounds how far back reads of single-point metadata
+	// series (promkv_file_version, promkv_file_sha256_bytes, and List's
+	// LabelValues lookup) search for the most recent write. A key that hasn't
+	// been written within LookbackWindow becomes invisible to List and Stat,
+	// and nextVersion can no longer find its previous version. Defaults to
+	// DefaultLookbackWindow.
+	LookbackWindow time.Duration
 }
 
-func New(opts Options) *KV {
-	return &KV{opts: opts}
+// DefaultLookbackWindow is the LookbackWindow used when Options.LookbackWindow
+// is unset.
+const DefaultLookbackWindow = 30 * 24 * time.Hour
+
+// lookbackWindow returns the configured LookbackWindow, or DefaultLookbackWindow
+// if unset.
+func (db *KV) lookbackWindow() time.Duration {
+	if db.opts.LookbackWindow <= 0 {
+		return DefaultLookbackWindow
+	}
+	return db.opts.LookbackWindow
 }
 
-func (db *KV) Get(ctx context.Context, name string) ([]byte, error) {
-	var _ promapi.API
+// New creates a new KV client.
+func New(opts Options) *KV {
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
 
-	cli, err := api.NewClient(api.Config{
-		Address: db.opts.APIURL,
-		Client:  db.opts.Client,
-	})
-	if err != nil {
-		return nil, err
+	return &KV{
+		opts:    opts,
+		logger:  logger,
+		metrics: newMetrics(opts.Registerer),
 	}
+}
 
-	api := promapi.NewAPI(cli)
-
-	val, _, err := api.QueryRange(
-		ctx,
-		fmt.Sprintf("promkv_file_size_bytes{key=%q}", name),
-		promapi.Range{
-			Start: time.Now().UTC().Add(-time.Hour),
-			End:   time.Now().UTC(),
-			Step:  time.Minute,
-		},
-	)
+// promAPI builds the Prometheus HTTP API client used to read values back out
+// of db.opts.APIURL.
+func (db *KV) promAPI() (promapi.API, error) {
+	httpCli, err := db.resolveClient()
 	if err != nil {
 		return nil, err
 	}
-	fileSizeBytes := getLastValue(val)
-
-	val, _, err = api.QueryRange(
-		ctx,
-		fmt.Sprintf("promkv_file_timestamp_seconds{key=%q}", name),
-		promapi.Range{
-			Start: time.Now().UTC().Add(-time.Hour),
-			End:   time.Now().UTC(),
-			Step:  time.Minute,
-		},
-	)
+
+	cli, err := api.NewClient(api.Config{
+		Address: db.opts.APIURL,
+		Client:  httpCli,
+	})
 	if err != nil {
 		return nil, err
 	}
-	timestampSeconds := getLastValue(val)
+	return promapi.NewAPI(cli), nil
+}
 
-	dataRange := promapi.Range{
-		Start: timestamp.Time(int64(timestampSeconds)),
-		End:   timestamp.Time(int64(timestampSeconds)).Add(time.Second * time.Duration(fileSizeBytes)),
-		Step:  time.Second,
+// resolveClient returns the *http.Client used for both remote_write pushes
+// and API reads. HTTPClientConfig takes precedence; Client is a deprecated
+// fallback for callers that haven't migrated yet.
+func (db *KV) resolveClient() (*http.Client, error) {
+	if !reflect.DeepEqual(db.opts.HTTPClientConfig, config.HTTPClientConfig{}) {
+		return config.NewClientFromConfig(db.opts.HTTPClientConfig, "promkv")
 	}
-
-	val, _, err = api.QueryRange(
-		ctx,
-		fmt.Sprintf("promkv_file_content{key=%q}", name),
-		dataRange,
-	)
-	if err != nil {
-		return nil, err
+	if db.opts.Client != nil {
+		return db.opts.Client, nil
 	}
+	return http.DefaultClient, nil
+}
 
-	floatBytes := getValues(val)[:int(fileSizeBytes)]
+// Get reads back the value previously stored under name, returning
+// *ErrCorrupted if the reassembled content doesn't match the sha256 digest
+// recorded when it was written.
+func (db *KV) Get(ctx context.Context, name string) ([]byte, error) {
+	start := time.Now()
 
+	r, err := db.GetStream(ctx, name)
 	var bb []byte
-	for _, fb := range floatBytes {
-		bb = append(bb, byte(fb))
+	if err == nil {
+		bb, err = io.ReadAll(r)
+	}
+	if err == nil {
+		var papi promapi.API
+		papi, err = db.promAPI()
+		if err == nil {
+			err = db.verifyChecksum(ctx, papi, name, bb)
+		}
 	}
-	return bb, nil
+
+	db.observe(ctx, "get", name, len(bb), start, err)
+	return bb, err
 }
 
-func getValues(val model.Value) []float64 {
-	switch val := val.(type) {
-	case model.Matrix:
-		samples := val[len(val)-1].Values
+// Set stores the contents of r under name.
+func (db *KV) Set(ctx context.Context, name string, r io.Reader) error {
+	start := time.Now()
 
-		var points []float64
-		for _, sample := range samples {
-			points = append(points, float64(sample.Value))
+	var size int
+	err := func() error {
+		w, err := db.SetStream(ctx, name)
+		if err != nil {
+			return err
 		}
-		return points
-
-	default:
-		panic(fmt.Sprintf("Unrecognized type %T", val))
-	}
+		n, err := io.Copy(w, r)
+		size = int(n)
+		if err != nil {
+			return err
+		}
+		return w.Close()
+	}()
 
+	db.observe(ctx, "set", name, size, start, err)
+	return err
 }
 
-func getLastValue(val model.Value) float64 {
-	switch val := val.(type) {
-	case model.Matrix:
-		samples := val[len(val)-1].Values
-		return float64(samples[len(samples)-1].Value)
-	default:
-		panic(fmt.Sprintf("Unrecognized type %T", val))
-	}
-}
+// observe records a completed Get/Set operation as both a structured log
+// line and a set of Prometheus metrics.
+func (db *KV) observe(ctx context.Context, op, key string, size int, start time.Time, err error) {
+	duration := time.Since(start)
 
-func (db *KV) Set(ctx context.Context, name string, r io.Reader) error {
-	req, err := buildWriteRequest(name, r)
+	status := "success"
+	level := slog.LevelInfo
+	args := []any{"op", op, "key", key, "size", size, "duration", duration}
 	if err != nil {
-		return err
+		status = "error"
+		level = slog.LevelError
+		args = append(args, "error", err)
+	}
+
+	db.metrics.operationsTotal.WithLabelValues(op, status).Inc()
+	db.metrics.operationDuration.WithLabelValues(op).Observe(duration.Seconds())
+	if err == nil {
+		db.metrics.bytesTransferred.WithLabelValues(op).Add(float64(size))
 	}
 
+	db.logger.Log(ctx, level, "promkv operation completed", args...)
+}
+
+// send marshals req, snappy-compresses it, and POSTs it to db.opts.WriteURL,
+// matching the remote_write wire format a real Prometheus client would use.
+func (db *KV) send(ctx context.Context, req *prompb.WriteRequest) error {
 	pBuf := proto.NewBuffer(nil)
 	if err := pBuf.Marshal(req); err != nil {
 		return err
@@ -154,12 +221,14 @@ func (db *KV) Set(ctx context.Context, name string, r io.Reader) error {
 	httpReq.Header.Set("User-Agent", "promkv/v0.0.0")
 	httpReq.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
 
-	cli := db.opts.Client
-	if cli == nil {
-		cli = http.DefaultClient
+	db.logger.Debug("promkv sending remote_write request", "series", len(req.Timeseries), "compressed_bytes", len(compressed))
+
+	httpCli, err := db.resolveClient()
+	if err != nil {
+		return err
 	}
 
-	httpResp, err := cli.Do(httpReq)
+	httpResp, err := httpCli.Do(httpReq)
 	if err != nil {
 		return err
 	}
@@ -179,79 +248,3 @@ func (db *KV) Set(ctx context.Context, name string, r io.Reader) error {
 
 	return nil
 }
-
-func buildWriteRequest(name string, r io.Reader) (*prompb.WriteRequest, error) {
-	req := &prompb.WriteRequest{
-		Metadata: []prompb.MetricMetadata{
-			{
-				Type:             prompb.MetricMetadata_GAUGE,
-				MetricFamilyName: "promkv_file_timestamp_seconds",
-				Help:             "Last timestamp when file was written.",
-				Unit:             "seconds",
-			},
-			{
-				Type:             prompb.MetricMetadata_GAUGE,
-				MetricFamilyName: "promkv_file_size_bytes",
-				Help:             "Size of file.",
-				Unit:             "bytes",
-			},
-			{
-				Type:             prompb.MetricMetadata_GAUGE,
-				MetricFamilyName: "promkv_file_content",
-				Help:             "Content of file.",
-			},
-		},
-	}
-
-	bb, err := io.ReadAll(r)
-	if err != nil {
-		return nil, err
-	}
-
-	var (
-		dataOffset = time.Second * time.Duration(len(bb))
-
-		// The startTimestamp is the timestamp of the very first byte written.
-		startTimestamp = time.Now().UTC().Add(-dataOffset)
-	)
-
-	req.Timeseries = append(req.Timeseries, prompb.TimeSeries{
-		Labels: []prompb.Label{
-			{Name: "__name__", Value: "promkv_file_timestamp_seconds"},
-			{Name: "key", Value: name},
-		},
-		Samples: []prompb.Sample{{
-			Timestamp: timestamp.FromTime(startTimestamp),
-			Value:     float64(timestamp.FromTime(startTimestamp)),
-		}},
-	}, prompb.TimeSeries{
-		Labels: []prompb.Label{
-			{Name: "__name__", Value: "promkv_file_size_bytes"},
-			{Name: "key", Value: name},
-		},
-		Samples: []prompb.Sample{{
-			Timestamp: timestamp.FromTime(startTimestamp),
-			Value:     float64(len(bb)),
-		}},
-	})
-
-	contentSeries := prompb.TimeSeries{
-		Labels: []prompb.Label{
-			{Name: "__name__", Value: "promkv_file_content"},
-			{Name: "key", Value: name},
-		},
-		Samples: make([]prompb.Sample, 0, len(bb)),
-	}
-	for i, b := range bb {
-		offset := time.Second * time.Duration(i)
-
-		contentSeries.Samples = append(contentSeries.Samples, prompb.Sample{
-			Value:     float64(b),
-			Timestamp: timestamp.FromTime(startTimestamp.Add(offset)),
-		})
-	}
-
-	req.Timeseries = append(req.Timeseries, contentSeries)
-
-	return req, nil
-}
@@ -0,0 +1,271 @@
+package promkv
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// Server is an in-process, Prometheus-compatible remote-write receiver. It
+// exposes just enough of the Prometheus HTTP API (/api/v1/write and
+// /api/v1/query_range) for a KV pointed at it to Get and Set values without
+// a real Prometheus running, mirroring upstream Prometheus's
+// remote-write-receiver feature flag.
+//
+// Server is useful for local development and integration tests: point a
+// KV's Options.APIURL and Options.WriteURL at an httptest.Server wrapping
+// Server.Handler() to exercise the full client without any external
+// dependencies.
+//
+// Server's query_range endpoint only answers JSON, so it does not currently
+// round-trip values written with Encoding: NativeHistogram, which requires
+// the protobuf MetricFamily exposition format to survive the trip; use a
+// real Prometheus for that encoding.
+//
+// Server also doesn't yet implement /api/v1/label/.../values or the admin
+// delete_series endpoint, so KV.List and KV.Delete need a real Prometheus
+// too.
+type Server struct {
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// series is an in-process TSDB-like entry: a label set and its samples,
+// kept sorted by timestamp.
+type series struct {
+	labels  map[string]string
+	samples []prompb.Sample
+}
+
+// NewServer creates an empty Server.
+func NewServer() *Server {
+	return &Server{series: make(map[string]*series)}
+}
+
+// Handler returns the http.Handler implementing Server's API surface.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/write", s.handleWrite)
+	mux.HandleFunc("/api/v1/query_range", s.handleQueryRange)
+	return mux
+}
+
+// handleWrite decodes a snappy-compressed prompb.WriteRequest, the same
+// wire format KV.Set already emits, and merges its series into the store.
+func (s *Server) handleWrite(w http.ResponseWriter, r *http.Request) {
+	compressed, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	bb, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("decoding snappy payload: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	var req prompb.WriteRequest
+	if err := proto.Unmarshal(bb, &req); err != nil {
+		http.Error(w, fmt.Sprintf("decoding write request: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, ts := range req.Timeseries {
+		key := seriesKey(ts.Labels)
+
+		ser, ok := s.series[key]
+		if !ok {
+			ser = &series{labels: labelMap(ts.Labels)}
+			s.series[key] = ser
+		}
+		ser.samples = append(ser.samples, ts.Samples...)
+		sort.Slice(ser.samples, func(i, j int) bool {
+			return ser.samples[i].Timestamp < ser.samples[j].Timestamp
+		})
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleQueryRange answers a PromQL-ish selector query (a metric name with
+// zero or more equality label matchers in braces, the only shape KV ever
+// issues) by returning matching series as model.Matrix JSON.
+func (s *Server) handleQueryRange(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	metric, matchers, err := parseSelector(r.Form.Get("query"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if metric != "" {
+		matchers["__name__"] = metric
+	}
+
+	start, err := parseTimeParam(r.Form.Get("start"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("parsing start: %s", err), http.StatusBadRequest)
+		return
+	}
+	end, err := parseTimeParam(r.Form.Get("end"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("parsing end: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	matrix := s.matchingMatrix(matchers, start, end)
+	s.mu.Unlock()
+
+	var resp queryRangeResponse
+	resp.Status = "success"
+	resp.Data.ResultType = "matrix"
+	resp.Data.Result = matrix
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// matchingMatrix must be called with s.mu held.
+func (s *Server) matchingMatrix(matchers map[string]string, start, end time.Time) model.Matrix {
+	matrix := make(model.Matrix, 0)
+
+	for _, ser := range s.series {
+		if !matches(ser.labels, matchers) {
+			continue
+		}
+
+		var pairs []model.SamplePair
+		for _, samp := range ser.samples {
+			ts := timestamp.Time(samp.Timestamp)
+			if ts.Before(start) || ts.After(end) {
+				continue
+			}
+			pairs = append(pairs, model.SamplePair{
+				Timestamp: model.TimeFromUnixNano(ts.UnixNano()),
+				Value:     model.SampleValue(samp.Value),
+			})
+		}
+		if len(pairs) == 0 {
+			continue
+		}
+
+		matrix = append(matrix, &model.SampleStream{
+			Metric: toMetric(ser.labels),
+			Values: pairs,
+		})
+	}
+
+	return matrix
+}
+
+// queryRangeResponse mirrors the Prometheus HTTP API's query_range response
+// envelope closely enough for promapi.API.QueryRange to parse it.
+type queryRangeResponse struct {
+	Status string `json:"status"`
+	Data   struct {
+		ResultType string       `json:"resultType"`
+		Result     model.Matrix `json:"result"`
+	} `json:"data"`
+}
+
+var matcherRe = regexp.MustCompile(`(\w+)="((?:[^"\\]|\\.)*)"`)
+
+// parseSelector splits a selector like `promkv_file_content{key="foo",chunk="0"}`
+// into its metric name and equality label matchers. It only understands the
+// subset of PromQL that KV itself generates.
+func parseSelector(query string) (metric string, matchers map[string]string, err error) {
+	query = strings.TrimSpace(query)
+	matchers = make(map[string]string)
+
+	open := strings.IndexByte(query, '{')
+	if open == -1 {
+		return query, matchers, nil
+	}
+	if !strings.HasSuffix(query, "}") {
+		return "", nil, fmt.Errorf("promkv: malformed selector %q", query)
+	}
+
+	metric = query[:open]
+	body := query[open+1 : len(query)-1]
+
+	for _, m := range matcherRe.FindAllStringSubmatch(body, -1) {
+		value, err := strconv.Unquote(`"` + m[2] + `"`)
+		if err != nil {
+			return "", nil, fmt.Errorf("promkv: malformed matcher value %q: %w", m[2], err)
+		}
+		matchers[m[1]] = value
+	}
+
+	return metric, matchers, nil
+}
+
+// parseTimeParam parses a Prometheus API time parameter, either a decimal
+// Unix timestamp in seconds or an RFC3339 string.
+func parseTimeParam(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, fmt.Errorf("missing time parameter")
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		sec := int64(f)
+		nsec := int64((f - float64(sec)) * float64(time.Second))
+		return time.Unix(sec, nsec).UTC(), nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+// seriesKey canonicalizes a label set into a map key, independent of label
+// order.
+func seriesKey(labels []prompb.Label) string {
+	pairs := make([]string, 0, len(labels))
+	for _, l := range labels {
+		pairs = append(pairs, l.Name+"=\""+l.Value+"\"")
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func labelMap(labels []prompb.Label) map[string]string {
+	m := make(map[string]string, len(labels))
+	for _, l := range labels {
+		m[l.Name] = l.Value
+	}
+	return m
+}
+
+func toMetric(labels map[string]string) model.Metric {
+	m := make(model.Metric, len(labels))
+	for k, v := range labels {
+		m[model.LabelName(k)] = model.LabelValue(v)
+	}
+	return m
+}
+
+func matches(labels, matchers map[string]string) bool {
+	for k, v := range matchers {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
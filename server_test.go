@@ -0,0 +1,57 @@
+package promkv_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rfratto/promkv"
+)
+
+func TestServerSetGetRoundTrip(t *testing.T) {
+	srv := promkv.NewServer()
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	db := promkv.New(promkv.Options{
+		APIURL:   ts.URL,
+		WriteURL: ts.URL + "/api/v1/write",
+	})
+
+	ctx := context.Background()
+	want := bytes.Repeat([]byte("promkv round trip test content"), 100)
+
+	if err := db.Set(ctx, "greeting", bytes.NewReader(want)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	r, err := db.GetStream(ctx, "greeting")
+	if err != nil {
+		t.Fatalf("GetStream: %v", err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("reading stream: %v", err)
+	}
+
+	if !bytes.Equal(got, want) {
+		t.Fatalf("round trip mismatch: got %d bytes, want %d bytes", len(got), len(want))
+	}
+}
+
+func TestServerGetMissingKey(t *testing.T) {
+	srv := promkv.NewServer()
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	db := promkv.New(promkv.Options{
+		APIURL:   ts.URL,
+		WriteURL: ts.URL + "/api/v1/write",
+	})
+
+	if _, err := db.Get(context.Background(), "never-written"); err == nil {
+		t.Fatal("Get on a never-written key returned no error")
+	}
+}
@@ -0,0 +1,494 @@
+package promkv
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"time"
+
+	promapi "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// DefaultChunkSize is the chunk size SetStream uses when Options.ChunkSize is
+// unset. Splitting a value into chunks bounds how large any single
+// remote_write request (and the QueryRange call needed to read it back) can
+// grow.
+const DefaultChunkSize = 64 * 1024
+
+// queryBoundaryMargin pads fetchChunk's QueryRange Start/End beyond the
+// exact window its content samples were written into. Start/End round-trip
+// through client_golang's decimal-seconds formatTime and this package's
+// parseTimeParam, a lossy float conversion that can shift a timestamp
+// sitting exactly on the window's edge by a fraction of a second; without
+// this margin that sample is silently excluded from the result.
+const queryBoundaryMargin = time.Second
+
+// fileFormatVersion is recorded in promkv_file_encoding_version on every
+// write. It exists so that future changes to how chunks and frames are laid
+// out on the wire (NativeHistogram was the first) let a mixed store of old
+// and new writes be told apart at read time instead of being silently
+// misinterpreted.
+const fileFormatVersion = 1
+
+// SetStream returns a writer that streams name's contents to Prometheus as
+// they're written, without buffering the whole value in memory. Writes are
+// batched into chunks of Options.ChunkSize bytes (DefaultChunkSize if
+// unset); each full chunk is pushed as its own remote_write request tagged
+// with a "chunk" label, packed according to Options.Encoding.
+//
+// The returned writer must be closed to flush any remaining buffered bytes
+// and record the file's metadata; errors from Write and Close must both be
+// checked.
+func (db *KV) SetStream(ctx context.Context, name string) (io.WriteCloser, error) {
+	chunkSize := db.opts.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	return &streamWriter{
+		ctx:       ctx,
+		db:        db,
+		name:      name,
+		chunkSize: chunkSize,
+		encoding:  db.opts.Encoding,
+		start:     time.Now().UTC(),
+		hash:      sha256.New(),
+	}, nil
+}
+
+// streamWriter implements io.WriteCloser for SetStream, buffering each
+// Write's bytes and then flushing chunkSize bytes at a time as remote_write
+// requests while at least chunkSize bytes are buffered.
+type streamWriter struct {
+	ctx       context.Context
+	db        *KV
+	name      string
+	chunkSize int
+	encoding  Encoding
+	start     time.Time
+	hash      hash.Hash
+
+	buf   bytes.Buffer
+	chunk int
+	total int
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	w.hash.Write(p)
+	w.total += len(p)
+	w.buf.Write(p)
+
+	for w.buf.Len() >= w.chunkSize {
+		if err := w.flushChunk(w.buf.Next(w.chunkSize)); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (w *streamWriter) flushChunk(bb []byte) error {
+	req, err := buildChunkWriteRequest(w.name, w.chunk, w.encoding, bb)
+	if err != nil {
+		return err
+	}
+	if err := w.db.send(w.ctx, req); err != nil {
+		return fmt.Errorf("writing chunk %d: %w", w.chunk, err)
+	}
+	w.chunk++
+	return nil
+}
+
+func (w *streamWriter) Close() error {
+	if w.buf.Len() > 0 {
+		bb := make([]byte, w.buf.Len())
+		copy(bb, w.buf.Bytes())
+		if err := w.flushChunk(bb); err != nil {
+			return err
+		}
+		w.buf.Reset()
+	}
+
+	w.db.metrics.framesPerRequest.Observe(float64(w.chunk))
+
+	papi, err := w.db.promAPI()
+	if err != nil {
+		return err
+	}
+	version, err := w.db.nextVersion(w.ctx, papi, w.name)
+	if err != nil {
+		return fmt.Errorf("reading version for %q: %w", w.name, err)
+	}
+
+	req := buildFileMetadataWriteRequest(w.name, w.chunk, w.encoding, w.total, w.start, version, w.hash.Sum(nil))
+	return w.db.send(w.ctx, req)
+}
+
+// GetStream reads back the value previously stored under name, fetching
+// every chunk in parallel (each with its own QueryRange calls for the
+// chunk's metadata and content) and reassembling the results in order. The returned reader streams chunks as they're decoded rather than
+// requiring the whole value to be assembled up front.
+func (db *KV) GetStream(ctx context.Context, name string) (io.Reader, error) {
+	papi, err := db.promAPI()
+	if err != nil {
+		return nil, err
+	}
+
+	numChunksF, err := db.queryScalar(ctx, papi, fmt.Sprintf("promkv_file_chunks{key=%q}", name))
+	if err != nil {
+		return nil, fmt.Errorf("reading chunk count for %q: %w", name, err)
+	}
+	encodingF, err := db.queryScalar(ctx, papi, fmt.Sprintf("promkv_file_encoding{key=%q}", name))
+	if err != nil {
+		return nil, fmt.Errorf("reading encoding for %q: %w", name, err)
+	}
+	versionF, err := db.queryScalar(ctx, papi, fmt.Sprintf("promkv_file_encoding_version{key=%q}", name))
+	if err != nil {
+		return nil, fmt.Errorf("reading encoding version for %q: %w", name, err)
+	}
+	if int(versionF) != fileFormatVersion {
+		return nil, fmt.Errorf("promkv: %q was written with encoding format version %d, this client understands version %d", name, int(versionF), fileFormatVersion)
+	}
+
+	var (
+		numChunks = int(numChunksF)
+		encoding  = Encoding(int(encodingF))
+	)
+	db.metrics.framesPerRequest.Observe(float64(numChunks))
+
+	type chunkResult struct {
+		bb  []byte
+		err error
+	}
+	results := make([]chan chunkResult, numChunks)
+	for i := range results {
+		results[i] = make(chan chunkResult, 1)
+		go func(i int) {
+			bb, err := db.fetchChunk(ctx, papi, name, i, encoding)
+			results[i] <- chunkResult{bb: bb, err: err}
+		}(i)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		for _, ch := range results {
+			res := <-ch
+			if res.err != nil {
+				pw.CloseWithError(res.err)
+				return
+			}
+			if _, err := pw.Write(res.bb); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.Close()
+	}()
+
+	return pr, nil
+}
+
+// fetchChunk downloads and decodes a single chunk of name.
+func (db *KV) fetchChunk(ctx context.Context, papi promapi.API, name string, chunk int, encoding Encoding) ([]byte, error) {
+	if encoding == NativeHistogram {
+		return db.fetchHistogramChunk(ctx, papi, name, chunk)
+	}
+
+	chunkLabel := fmt.Sprintf("key=%q,chunk=%q", name, strconv.Itoa(chunk))
+
+	sizeBytes, err := db.queryScalar(ctx, papi, fmt.Sprintf("promkv_file_chunk_size_bytes{%s}", chunkLabel))
+	if err != nil {
+		return nil, fmt.Errorf("reading chunk %d size: %w", chunk, err)
+	}
+	packedBytes, err := db.queryScalar(ctx, papi, fmt.Sprintf("promkv_file_chunk_packed_bytes{%s}", chunkLabel))
+	if err != nil {
+		return nil, fmt.Errorf("reading chunk %d packed size: %w", chunk, err)
+	}
+	numSamples, err := db.queryScalar(ctx, papi, fmt.Sprintf("promkv_file_chunk_samples{%s}", chunkLabel))
+	if err != nil {
+		return nil, fmt.Errorf("reading chunk %d sample count: %w", chunk, err)
+	}
+	startSeconds, err := db.queryScalar(ctx, papi, fmt.Sprintf("promkv_file_chunk_timestamp_seconds{%s}", chunkLabel))
+	if err != nil {
+		return nil, fmt.Errorf("reading chunk %d timestamp: %w", chunk, err)
+	}
+
+	start := timestamp.Time(int64(startSeconds))
+
+	val, _, err := papi.QueryRange(
+		ctx,
+		fmt.Sprintf("promkv_file_content{%s}", chunkLabel),
+		promapi.Range{
+			Start: start.Add(-queryBoundaryMargin),
+			End:   start.Add(time.Second * time.Duration(numSamples)).Add(queryBoundaryMargin),
+			Step:  time.Second,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// The margin above exists only to keep the boundary samples from being
+	// dropped by a lossy float round trip; this series was never written
+	// with more than numSamples points, so trim back down to that in case it
+	// ever widens enough to pick up a neighboring sample instead.
+	values := getValues(val)
+	if len(values) > int(numSamples) {
+		values = values[:int(numSamples)]
+	}
+
+	return unpackSamples(encoding, values, int(sizeBytes), int(packedBytes))
+}
+
+// queryScalar runs query as an instant-ish QueryRange lookup (matching the
+// rest of this package) and returns the most recent sample value. It
+// returns ErrNotFound if query matched no series.
+func (db *KV) queryScalar(ctx context.Context, papi promapi.API, query string) (float64, error) {
+	v, found, err := db.queryScalarOptional(ctx, papi, query)
+	if err != nil {
+		return 0, err
+	}
+	if !found {
+		return 0, ErrNotFound
+	}
+	return v, nil
+}
+
+// queryScalarOptional behaves like queryScalar but reports a key that has
+// never been written (and therefore has no samples yet) as found=false
+// instead of panicking inside getLastValue.
+func (db *KV) queryScalarOptional(ctx context.Context, papi promapi.API, query string) (value float64, found bool, err error) {
+	val, _, err := papi.QueryRange(
+		ctx,
+		query,
+		promapi.Range{
+			Start: time.Now().UTC().Add(-db.lookbackWindow()),
+			End:   time.Now().UTC(),
+			Step:  time.Minute,
+		},
+	)
+	if err != nil {
+		return 0, false, err
+	}
+
+	matrix, ok := val.(model.Matrix)
+	if !ok || len(matrix) == 0 || len(matrix[len(matrix)-1].Values) == 0 {
+		return 0, false, nil
+	}
+	return getLastValue(val), true, nil
+}
+
+// nextVersion returns the version number a write to name should record:
+// one more than whatever's already stored, or 1 if name has never been
+// written.
+func (db *KV) nextVersion(ctx context.Context, papi promapi.API, name string) (uint64, error) {
+	v, found, err := db.queryScalarOptional(ctx, papi, fmt.Sprintf("promkv_file_version{key=%q}", name))
+	if err != nil {
+		return 0, err
+	}
+	if !found {
+		return 1, nil
+	}
+	return uint64(v) + 1, nil
+}
+
+func getValues(val model.Value) []float64 {
+	switch val := val.(type) {
+	case model.Matrix:
+		if len(val) == 0 {
+			return nil
+		}
+		samples := val[len(val)-1].Values
+
+		points := make([]float64, 0, len(samples))
+		for _, sample := range samples {
+			points = append(points, float64(sample.Value))
+		}
+		return points
+
+	default:
+		panic(fmt.Sprintf("Unrecognized type %T", val))
+	}
+}
+
+func getLastValue(val model.Value) float64 {
+	switch val := val.(type) {
+	case model.Matrix:
+		samples := val[len(val)-1].Values
+		return float64(samples[len(samples)-1].Value)
+	default:
+		panic(fmt.Sprintf("Unrecognized type %T", val))
+	}
+}
+
+// buildChunkWriteRequest builds the remote_write request for a single chunk
+// of a streamed value.
+func buildChunkWriteRequest(name string, chunk int, encoding Encoding, bb []byte) (*prompb.WriteRequest, error) {
+	if encoding == NativeHistogram {
+		return buildHistogramChunkWriteRequest(name, chunk, bb)
+	}
+
+	samples, packedLen, err := packSamples(encoding, bb)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		chunkStr = strconv.Itoa(chunk)
+
+		dataOffset     = time.Second * time.Duration(len(samples))
+		startTimestamp = time.Now().UTC().Add(-dataOffset)
+	)
+
+	contentSeries := prompb.TimeSeries{
+		Labels: []prompb.Label{
+			{Name: "__name__", Value: "promkv_file_content"},
+			{Name: "key", Value: name},
+			{Name: "chunk", Value: chunkStr},
+		},
+		Samples: make([]prompb.Sample, 0, len(samples)),
+	}
+	for i, v := range samples {
+		offset := time.Second * time.Duration(i)
+		contentSeries.Samples = append(contentSeries.Samples, prompb.Sample{
+			Value:     v,
+			Timestamp: timestamp.FromTime(startTimestamp.Add(offset)),
+		})
+	}
+
+	return &prompb.WriteRequest{
+		Metadata: []prompb.MetricMetadata{
+			{
+				Type:             prompb.MetricMetadata_GAUGE,
+				MetricFamilyName: "promkv_file_content",
+				Help:             "Content of a file chunk.",
+			},
+		},
+		Timeseries: []prompb.TimeSeries{
+			gaugeSeries("promkv_file_chunk_size_bytes", name, chunkStr, float64(len(bb)), startTimestamp),
+			gaugeSeries("promkv_file_chunk_packed_bytes", name, chunkStr, float64(packedLen), startTimestamp),
+			gaugeSeries("promkv_file_chunk_samples", name, chunkStr, float64(len(samples)), startTimestamp),
+			gaugeSeries("promkv_file_chunk_timestamp_seconds", name, chunkStr, float64(timestamp.FromTime(startTimestamp)), startTimestamp),
+			contentSeries,
+		},
+	}, nil
+}
+
+// buildFileMetadataWriteRequest builds the remote_write request recording
+// the overall shape of a streamed value, written once the value is fully
+// flushed. version is the write's monotonic version number and sum is the
+// sha256 digest of the full value, used by Stat and Get to detect
+// corruption.
+func buildFileMetadataWriteRequest(name string, numChunks int, encoding Encoding, totalSize int, start time.Time, version uint64, sum []byte) *prompb.WriteRequest {
+	return &prompb.WriteRequest{
+		Metadata: []prompb.MetricMetadata{
+			{
+				Type:             prompb.MetricMetadata_GAUGE,
+				MetricFamilyName: "promkv_file_chunks",
+				Help:             "Number of chunks the file was split across.",
+			},
+			{
+				Type:             prompb.MetricMetadata_GAUGE,
+				MetricFamilyName: "promkv_file_encoding",
+				Help:             "Encoding used to pack the file's bytes into samples.",
+			},
+			{
+				Type:             prompb.MetricMetadata_GAUGE,
+				MetricFamilyName: "promkv_file_encoding_version",
+				Help:             "Version of the chunk/frame layout this file was written with.",
+			},
+			{
+				Type:             prompb.MetricMetadata_GAUGE,
+				MetricFamilyName: "promkv_file_size_bytes",
+				Help:             "Size of file.",
+				Unit:             "bytes",
+			},
+			{
+				Type:             prompb.MetricMetadata_GAUGE,
+				MetricFamilyName: "promkv_file_timestamp_seconds",
+				Help:             "Last timestamp when file was written.",
+				Unit:             "seconds",
+			},
+			{
+				Type:             prompb.MetricMetadata_GAUGE,
+				MetricFamilyName: "promkv_file_version",
+				Help:             "Monotonic version counter, incremented on every write.",
+			},
+			{
+				Type:             prompb.MetricMetadata_GAUGE,
+				MetricFamilyName: "promkv_file_sha256_bytes",
+				Help:             "sha256 digest of the file's content, one byte per sample.",
+			},
+		},
+		Timeseries: []prompb.TimeSeries{
+			keySeries("promkv_file_chunks", name, float64(numChunks), start),
+			keySeries("promkv_file_encoding", name, float64(encoding), start),
+			keySeries("promkv_file_encoding_version", name, float64(fileFormatVersion), start),
+			keySeries("promkv_file_size_bytes", name, float64(totalSize), start),
+			keySeries("promkv_file_timestamp_seconds", name, float64(timestamp.FromTime(start)), start),
+			keySeries("promkv_file_version", name, float64(version), start),
+			sha256Series(name, sum, start),
+		},
+	}
+}
+
+// sha256Series builds the promkv_file_sha256_bytes series: one sample per
+// digest byte, laid out the same way OneBytePerSample packs file content.
+// Samples are backdated from at so the last one lands on at rather than
+// 31 seconds after it; every read path caps its QueryRange End at the
+// moment of the read, so future-dated samples would be silently dropped.
+func sha256Series(name string, sum []byte, at time.Time) prompb.TimeSeries {
+	start := at.Add(-time.Second * time.Duration(len(sum)-1))
+
+	samples := make([]prompb.Sample, len(sum))
+	for i, b := range sum {
+		samples[i] = prompb.Sample{
+			Value:     float64(b),
+			Timestamp: timestamp.FromTime(start.Add(time.Second * time.Duration(i))),
+		}
+	}
+
+	return prompb.TimeSeries{
+		Labels: []prompb.Label{
+			{Name: "__name__", Value: "promkv_file_sha256_bytes"},
+			{Name: "key", Value: name},
+		},
+		Samples: samples,
+	}
+}
+
+// keySeries builds a single-sample gauge time series labeled only with key.
+func keySeries(metric, key string, value float64, at time.Time) prompb.TimeSeries {
+	return prompb.TimeSeries{
+		Labels: []prompb.Label{
+			{Name: "__name__", Value: metric},
+			{Name: "key", Value: key},
+		},
+		Samples: []prompb.Sample{{
+			Timestamp: timestamp.FromTime(at),
+			Value:     value,
+		}},
+	}
+}
+
+// gaugeSeries builds a single-sample gauge time series labeled with both key
+// and chunk.
+func gaugeSeries(metric, key, chunk string, value float64, at time.Time) prompb.TimeSeries {
+	return prompb.TimeSeries{
+		Labels: []prompb.Label{
+			{Name: "__name__", Value: metric},
+			{Name: "key", Value: key},
+			{Name: "chunk", Value: chunk},
+		},
+		Samples: []prompb.Sample{{
+			Timestamp: timestamp.FromTime(at),
+			Value:     value,
+		}},
+	}
+}